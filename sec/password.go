@@ -0,0 +1,163 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PasswordProvider supplies the passphrase for an encrypted key.
+// prompt identifies which key is being unlocked, so a caller handling
+// several keys at once (e.g. a signing tool with a root and a
+// per-image key) can tell them apart.
+type PasswordProvider interface {
+	Password(prompt string) ([]byte, error)
+}
+
+// passwordProvider is consulted by getPassword whenever KeyPassword
+// isn't set.  It defaults to prompting on the controlling terminal, as
+// this package has always done.
+var passwordProvider PasswordProvider = terminalPasswordProvider{}
+
+// SetPasswordProvider replaces the package's PasswordProvider.  Use
+// this to run in CI, behind a GUI, or anywhere else without a
+// controlling TTY.
+func SetPasswordProvider(p PasswordProvider) {
+	passwordProvider = p
+}
+
+type terminalPasswordProvider struct{}
+
+func (terminalPasswordProvider) Password(prompt string) ([]byte, error) {
+	fmt.Printf("%s: ", prompt)
+	return terminal.ReadPassword(0)
+}
+
+// envPasswordProvider reads the passphrase from an environment
+// variable.
+type envPasswordProvider struct {
+	name string
+}
+
+// EnvPasswordProvider returns a PasswordProvider that reads the
+// passphrase from the named environment variable.
+func EnvPasswordProvider(name string) PasswordProvider {
+	return envPasswordProvider{name: name}
+}
+
+func (p envPasswordProvider) Password(prompt string) ([]byte, error) {
+	val, ok := os.LookupEnv(p.name)
+	if !ok {
+		return nil, fmt.Errorf("sec: environment variable %s is not set (%s)", p.name, prompt)
+	}
+	return []byte(val), nil
+}
+
+// filePasswordProvider reads the passphrase from the first line of a
+// file.
+type filePasswordProvider struct {
+	path string
+}
+
+// FilePasswordProvider returns a PasswordProvider that reads the
+// passphrase from the first line of the named file, with any trailing
+// newline trimmed.
+func FilePasswordProvider(path string) PasswordProvider {
+	return filePasswordProvider{path: path}
+}
+
+func (p filePasswordProvider) Password(prompt string) ([]byte, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("sec: reading password file for %s: %v", prompt, err)
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("sec: reading password file for %s: %v", prompt, err)
+	}
+
+	return bytes.TrimRight([]byte(line), "\r\n"), nil
+}
+
+// execPasswordProvider runs an external helper and reads the
+// passphrase from its stdout, in the same spirit as git's
+// credential.helper.
+type execPasswordProvider struct {
+	name string
+	args []string
+}
+
+// ExecPasswordProvider returns a PasswordProvider that runs the named
+// binary (with args, if any) and reads the passphrase from its first
+// line of stdout, with any trailing newline trimmed.  prompt is
+// appended as a final argument so the helper can display it.
+func ExecPasswordProvider(name string, args ...string) PasswordProvider {
+	return execPasswordProvider{name: name, args: args}
+}
+
+func (p execPasswordProvider) Password(prompt string) ([]byte, error) {
+	cmd := exec.Command(p.name, append(append([]string{}, p.args...), prompt)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sec: running password helper %s: %v", p.name, err)
+	}
+
+	line := out
+	if idx := bytes.IndexByte(line, '\n'); idx >= 0 {
+		line = line[:idx]
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// staticPasswordProvider always returns the same passphrase.  It's
+// the in-memory equivalent of setting KeyPassword, for tests and
+// library embedders that already have the passphrase in hand.
+type staticPasswordProvider struct {
+	pass []byte
+}
+
+// StaticPasswordProvider returns a PasswordProvider that always
+// returns pass, ignoring the prompt.
+func StaticPasswordProvider(pass []byte) PasswordProvider {
+	return staticPasswordProvider{pass: pass}
+}
+
+func (p staticPasswordProvider) Password(prompt string) ([]byte, error) {
+	return p.pass, nil
+}
+
+// getPassword returns the passphrase to use for the key identified by
+// prompt.  KeyPassword, when set, takes priority for tests; otherwise
+// the configured PasswordProvider is consulted.
+func getPassword(prompt string) ([]byte, error) {
+	if len(KeyPassword) != 0 {
+		return KeyPassword, nil
+	}
+
+	return passwordProvider.Password(prompt)
+}
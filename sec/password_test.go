@@ -0,0 +1,147 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEnvPasswordProvider(t *testing.T) {
+	t.Setenv("SEC_TEST_PASSWORD", "hunter2")
+
+	p := EnvPasswordProvider("SEC_TEST_PASSWORD")
+	pass, err := p.Password("test key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "hunter2" {
+		t.Fatalf("got %q, want %q", pass, "hunter2")
+	}
+}
+
+func TestEnvPasswordProviderUnset(t *testing.T) {
+	p := EnvPasswordProvider("SEC_TEST_PASSWORD_NOT_SET")
+	if _, err := p.Password("test key"); err == nil {
+		t.Fatal("expected an error for an unset environment variable")
+	}
+}
+
+func TestFilePasswordProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pass.txt")
+	if err := os.WriteFile(path, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := FilePasswordProvider(path)
+	pass, err := p.Password("test key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "hunter2" {
+		t.Fatalf("got %q, want %q", pass, "hunter2")
+	}
+}
+
+func TestFilePasswordProviderNoTrailingNewline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pass.txt")
+	if err := os.WriteFile(path, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := FilePasswordProvider(path)
+	pass, err := p.Password("test key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "hunter2" {
+		t.Fatalf("got %q, want %q", pass, "hunter2")
+	}
+}
+
+func TestExecPasswordProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test script is a shell script")
+	}
+
+	script := filepath.Join(t.TempDir(), "helper.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hunter2\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	p := ExecPasswordProvider(script)
+	pass, err := p.Password("test key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "hunter2" {
+		t.Fatalf("got %q, want %q", pass, "hunter2")
+	}
+}
+
+func TestStaticPasswordProvider(t *testing.T) {
+	p := StaticPasswordProvider([]byte("hunter2"))
+	pass, err := p.Password("whichever key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "hunter2" {
+		t.Fatalf("got %q, want %q", pass, "hunter2")
+	}
+}
+
+// TestGetPasswordPrefersKeyPassword confirms KeyPassword still takes
+// priority over the configured PasswordProvider, as it did before
+// PasswordProvider existed.
+func TestGetPasswordPrefersKeyPassword(t *testing.T) {
+	orig := passwordProvider
+	defer func() { passwordProvider = orig; KeyPassword = nil }()
+
+	passwordProvider = StaticPasswordProvider([]byte("from provider"))
+	KeyPassword = []byte("from KeyPassword")
+
+	pass, err := getPassword("test key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "from KeyPassword" {
+		t.Fatalf("got %q, want %q", pass, "from KeyPassword")
+	}
+}
+
+// TestSetPasswordProvider confirms SetPasswordProvider's provider is
+// consulted once KeyPassword is unset.
+func TestSetPasswordProvider(t *testing.T) {
+	orig := passwordProvider
+	defer func() { passwordProvider = orig; KeyPassword = nil }()
+
+	KeyPassword = nil
+	SetPasswordProvider(StaticPasswordProvider([]byte("from provider")))
+
+	pass, err := getPassword("test key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "from provider" {
+		t.Fatalf("got %q, want %q", pass, "from provider")
+	}
+}
@@ -23,8 +23,10 @@ package sec
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/des"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -32,7 +34,7 @@ import (
 	"hash"
 
 	"golang.org/x/crypto/pbkdf2"
-	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/crypto/scrypt"
 )
 
 var (
@@ -41,8 +43,15 @@ var (
 	oidHmacWithSha1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
 	oidHmacWithSha224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
 	oidHmacWithSha256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHmacWithSha384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHmacWithSha512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
 	oidAes128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
 	oidAes256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDesEde3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAes128GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAes192GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	oidAes256GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
 )
 
 // We only support a narrow set of possible key types, namely the type
@@ -76,80 +85,209 @@ type pbkdf2Param struct {
 
 type hashFunc func() hash.Hash
 
-func parseEncryptedPrivateKey(der []byte) (key interface{}, err error) {
-	var wrapper pkcs5
-	if _, err = asn1.Unmarshal(der, &wrapper); err != nil {
-		return nil, err
+// prfSpec is one entry of prfSpecs: the HMAC PRF a PBKDF2Param's
+// HashFunc can name.
+type prfSpec struct {
+	oid     asn1.ObjectIdentifier
+	newHash hashFunc
+}
+
+var prfSpecs = []prfSpec{
+	{oidHmacWithSha1, sha1.New},
+	{oidHmacWithSha224, sha256.New224},
+	{oidHmacWithSha256, sha256.New},
+	{oidHmacWithSha384, sha512.New384},
+	{oidHmacWithSha512, sha512.New},
+}
+
+func lookupPRF(oid asn1.ObjectIdentifier) hashFunc {
+	for _, s := range prfSpecs {
+		if s.oid.Equal(oid) {
+			return s.newHash
+		}
 	}
-	if !wrapper.Algo.Algorithm.Equal(oidPbes2) {
-		return nil, fmt.Errorf("pkcs5: Unknown PKCS#5 wrapper algorithm: %v", wrapper.Algo.Algorithm)
+	return nil
+}
+
+// cipherSpec is one entry of cipherSpecs: the symmetric cipher a
+// PBES2 EncryptionScheme can name, and how to build it.  CBC ciphers
+// take their IV straight from EncryptionScheme.Parameters; AEAD
+// ciphers take a GCMParameters instead, and authenticate rather than
+// pad.
+type cipherSpec struct {
+	oid      asn1.ObjectIdentifier
+	keySize  int
+	aead     bool
+	newBlock func(key []byte) (cipher.Block, error)
+}
+
+var cipherSpecs = []cipherSpec{
+	{oidAes128CBC, 16, false, aes.NewCipher},
+	{oidAes256CBC, 32, false, aes.NewCipher},
+	{oidDesEde3CBC, 24, false, des.NewTripleDESCipher},
+	{oidAes128GCM, 16, true, aes.NewCipher},
+	{oidAes192GCM, 24, true, aes.NewCipher},
+	{oidAes256GCM, 32, true, aes.NewCipher},
+}
+
+func lookupCipherSpec(oid asn1.ObjectIdentifier) *cipherSpec {
+	for i := range cipherSpecs {
+		if cipherSpecs[i].oid.Equal(oid) {
+			return &cipherSpecs[i]
+		}
 	}
+	return nil
+}
 
-	var pbparm pbes2
-	if _, err = asn1.Unmarshal(wrapper.Algo.Parameters.FullBytes, &pbparm); err != nil {
+// gcmParameters is the PBES2 EncryptionScheme parameter struct for
+// the AES-GCM OIDs (RFC 5084):
+//
+//	GCMParameters ::= SEQUENCE {
+//	    aes-nonce        OCTET STRING,
+//	    aes-ICVlen       AES-GCM-ICVlen DEFAULT 12 }
+type gcmParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// scryptParams is the PBES2 KDF parameter struct when
+// KeyDerivationFunc is oidScrypt (RFC 7914 section 7).  KeyLength is
+// rarely present in practice; when absent we derive exactly the
+// number of bytes the chosen cipher needs.
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// parseEncryptedPrivateKey decodes an encrypted PKCS#8 key (or a
+// PKCS#12 bundle containing one), prompting for its passphrase via
+// label if one isn't already known (KeyPassword, or whatever the
+// configured PasswordProvider returns).  label lets a caller handling
+// several keys at once distinguish the prompts, e.g. "signing key" vs
+// "root key".
+func parseEncryptedPrivateKey(der []byte, label string) (key interface{}, err error) {
+	pass, err := getPassword(label)
+	if err != nil {
 		return nil, err
 	}
-	if !pbparm.KeyDerivationFunc.Algorithm.Equal(oidPbkdf2) {
-		return nil, fmt.Errorf("pkcs5: Unknown KDF: %v", pbparm.KeyDerivationFunc.Algorithm)
+
+	var wrapper pkcs5
+	if _, asn1Err := asn1.Unmarshal(der, &wrapper); asn1Err == nil && wrapper.Algo.Algorithm.Equal(oidPbes2) {
+		return decryptPbes2(&wrapper, pass)
 	}
 
-	var kdfParam pbkdf2Param
-	if _, err = asn1.Unmarshal(pbparm.KeyDerivationFunc.Parameters.FullBytes, &kdfParam); err != nil {
+	// Not a bare PKCS#5 wrapper (or one we don't recognize): the file
+	// may be a PKCS#12 bundle (.p12/.pfx) exported from a browser,
+	// HSM, or OS keystore, whose top-level structure is a PFX
+	// (Version INTEGER, AuthSafe, ...) rather than a pkcs5 wrapper, and
+	// which wraps the same kind of encrypted PrivateKeyInfo one level
+	// deeper.
+	if key, _, err2 := parsePkcs12(der, pass); err2 == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("pkcs5: Unrecognized key format: neither a supported PBES2 PKCS#8 wrapper nor a valid PKCS#12 bundle")
+}
+
+// decryptPbes2 unwraps a PBES2/PBKDF2/AES-CBC EncryptedPrivateKeyInfo,
+// given the already-obtained passphrase.  It is shared by
+// parseEncryptedPrivateKey and the PKCS#12 pkcs8ShroudedKeyBag path in
+// pkcs12.go, which both produce the same pkcs5 wrapper shape.
+func decryptPbes2(wrapper *pkcs5, pass []byte) (key interface{}, err error) {
+	var pbparm pbes2
+	if _, err = asn1.Unmarshal(wrapper.Algo.Parameters.FullBytes, &pbparm); err != nil {
 		return nil, err
 	}
 
-	var hashNew hashFunc
-	switch {
-	case kdfParam.HashFunc.Algorithm.Equal(oidHmacWithSha1):
-		hashNew = sha1.New
-	case kdfParam.HashFunc.Algorithm.Equal(oidHmacWithSha224):
-		hashNew = sha256.New224
-	case kdfParam.HashFunc.Algorithm.Equal(oidHmacWithSha256):
-		hashNew = sha256.New
-	default:
-		return nil, fmt.Errorf("pkcs5: Unsupported hash: %v", pbparm.EncryptionScheme.Algorithm)
+	spec := lookupCipherSpec(pbparm.EncryptionScheme.Algorithm)
+	if spec == nil {
+		return nil, fmt.Errorf("pkcs5: Unsupported cipher: %v", pbparm.EncryptionScheme.Algorithm)
 	}
 
-	// Get the encryption used.
-	size := 0
-	var iv []byte
+	var cryptoKey []byte
 	switch {
-	case pbparm.EncryptionScheme.Algorithm.Equal(oidAes256CBC):
-		size = 32
-		if _, err = asn1.Unmarshal(pbparm.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+	case pbparm.KeyDerivationFunc.Algorithm.Equal(oidPbkdf2):
+		var kdfParam pbkdf2Param
+		if _, err = asn1.Unmarshal(pbparm.KeyDerivationFunc.Parameters.FullBytes, &kdfParam); err != nil {
 			return nil, err
 		}
-	case pbparm.EncryptionScheme.Algorithm.Equal(oidAes128CBC):
-		size = 16
-		if _, err = asn1.Unmarshal(pbparm.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+
+		hashNew := lookupPRF(kdfParam.HashFunc.Algorithm)
+		if hashNew == nil {
+			return nil, fmt.Errorf("pkcs5: Unsupported hash: %v", kdfParam.HashFunc.Algorithm)
+		}
+
+		cryptoKey = pbkdf2.Key(pass, kdfParam.Salt, kdfParam.IterCount, spec.keySize, hashNew)
+
+	case pbparm.KeyDerivationFunc.Algorithm.Equal(oidScrypt):
+		var kdfParam scryptParams
+		if _, err = asn1.Unmarshal(pbparm.KeyDerivationFunc.Parameters.FullBytes, &kdfParam); err != nil {
 			return nil, err
 		}
+
+		cryptoKey, err = scrypt.Key(pass, kdfParam.Salt, kdfParam.CostParameter,
+			kdfParam.BlockSize, kdfParam.ParallelizationParameter, spec.keySize)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs5: scrypt: %v", err)
+		}
+
 	default:
-		return nil, fmt.Errorf("pkcs5: Unsupported cipher: %v", pbparm.EncryptionScheme.Algorithm)
+		return nil, fmt.Errorf("pkcs5: Unknown KDF: %v", pbparm.KeyDerivationFunc.Algorithm)
+	}
+
+	block, err := spec.newBlock(cryptoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.aead {
+		var gcmParam gcmParameters
+		if _, err = asn1.Unmarshal(pbparm.EncryptionScheme.Parameters.FullBytes, &gcmParam); err != nil {
+			return nil, err
+		}
+		return decryptGcm(block, gcmParam.Nonce, gcmParam.ICVLen, wrapper.Encrypted)
 	}
 
-	return unwrapPbes2Pbkdf2(&kdfParam, size, iv, hashNew, wrapper.Encrypted)
+	var iv []byte
+	if _, err = asn1.Unmarshal(pbparm.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	return decryptCbc(block, iv, wrapper.Encrypted)
 }
 
-func unwrapPbes2Pbkdf2(param *pbkdf2Param, size int, iv []byte, hashNew hashFunc, encrypted []byte) (key interface{}, err error) {
-	pass, err := getPassword()
+func decryptCbc(block cipher.Block, iv, encrypted []byte) (key interface{}, err error) {
+	plain := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, encrypted)
+
+	plain, err = checkPkcs7Padding(plain, block.BlockSize())
 	if err != nil {
 		return nil, err
 	}
-	cryptoKey := pbkdf2.Key(pass, param.Salt, param.IterCount, size, hashNew)
 
-	block, err := aes.NewCipher(cryptoKey)
+	return x509.ParsePKCS8PrivateKey(plain)
+}
+
+// decryptGcm opens an AES-GCM encrypted PrivateKeyInfo.  GCM
+// authenticates the ciphertext itself, so unlike decryptCbc there's
+// no padding to check afterwards.
+func decryptGcm(block cipher.Block, nonce []byte, icvLen int, encrypted []byte) (key interface{}, err error) {
+	aead, err := cipher.NewGCMWithTagSize(block, icvLen)
 	if err != nil {
 		return nil, err
 	}
-	enc := cipher.NewCBCDecrypter(block, iv)
 
-	plain := make([]byte, len(encrypted))
-	enc.CryptBlocks(plain, encrypted)
+	// aead.Open panics on a nonce of the wrong length; this is parsing
+	// untrusted input, so that has to be a returned error instead.
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("pkcs5: invalid GCM nonce length: got %d, want %d", len(nonce), aead.NonceSize())
+	}
 
-	plain, err = checkPkcs7Padding(plain)
+	plain, err := aead.Open(nil, nonce, encrypted, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("pkcs5: %v", err)
 	}
 
 	return x509.ParsePKCS8PrivateKey(plain)
@@ -157,13 +295,13 @@ func unwrapPbes2Pbkdf2(param *pbkdf2Param, size int, iv []byte, hashNew hashFunc
 
 // Verify that PKCS#7 padding is correct on this plaintext message.
 // Returns a new slice with the padding removed.
-func checkPkcs7Padding(buf []byte) ([]byte, error) {
-	if len(buf) < 16 {
+func checkPkcs7Padding(buf []byte, blockSize int) ([]byte, error) {
+	if len(buf) < blockSize {
 		return nil, fmt.Errorf("Invalid padded buffer")
 	}
 
 	padLen := int(buf[len(buf)-1])
-	if padLen < 1 || padLen > 16 {
+	if padLen < 1 || padLen > blockSize {
 		return nil, fmt.Errorf("Invalid padded buffer")
 	}
 
@@ -180,17 +318,6 @@ func checkPkcs7Padding(buf []byte) ([]byte, error) {
 	return buf[:len(buf)-padLen], nil
 }
 
-// For testing, a key can be set here.  If this is empty, the key will
-// be queried via prompt.
+// For testing, a key can be set here.  If this is empty, the
+// configured PasswordProvider will be consulted; see password.go.
 var KeyPassword = []byte{}
-
-// Prompt the user for a password, unless we have stored one for
-// testing.
-func getPassword() ([]byte, error) {
-	if len(KeyPassword) != 0 {
-		return KeyPassword, nil
-	}
-
-	fmt.Printf("key password: ")
-	return terminal.ReadPassword(0)
-}
@@ -0,0 +1,365 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Reader for PKCS#12 (.p12/.pfx) bundles, as exported by browsers,
+// HSMs, and the Windows/macOS keystores: RFC 7292.
+//
+// Only enough of RFC 7292 is implemented to pull the first private key
+// (and, if present, its leaf certificate) out of a bundle: the PBE
+// KDF, BMPString passwords, PBES1/3DES-CBC and PBES2 (shared with
+// pkcs.go) content encryption, MAC verification, and safe-bag
+// walking.  RC2, which some tools still use to encrypt the
+// certificate SafeContents, lives in pkcs12_rc2.go behind the
+// `pkcs12rc2` build tag so this file has no RC2 dependency by default.
+package sec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	oidPkcs7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPkcs7EncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPkcs8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidPbeWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPbeWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+
+	oidSHA1 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+// errRC2Disabled is returned by the default (non-pkcs12rc2) build when
+// a bundle needs RC2 to decrypt its certificate SafeContents.
+var errRC2Disabled = fmt.Errorf("pkcs12: bundle uses RC2, rebuild with -tags pkcs12rc2 to read it")
+
+// pfxPdu is the top-level PFX structure (RFC 7292 section 4).
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// safeBag is one entry of a SafeContents (RFC 7292 section 4.2).
+type safeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"explicit,tag:0"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	Id     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+// pbeParams is the PBES1 parameter struct (PKCS#12 section 4,
+// PKCS#5 PBES1): a salt and an iteration count, nothing else.
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type certBag struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// parsePkcs12 extracts the first private key (and leaf certificate, if
+// any) out of a PKCS#12 bundle.  key has the same dynamic type
+// (*rsa.PrivateKey or *ecdsa.PrivateKey) as parseEncryptedPrivateKey
+// returns.
+func parsePkcs12(der []byte, passphrase []byte) (key interface{}, cert *x509.Certificate, err error) {
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(der, &pfx); err != nil {
+		return nil, nil, fmt.Errorf("pkcs12: %v", err)
+	}
+
+	if !pfx.AuthSafe.ContentType.Equal(oidPkcs7Data) {
+		return nil, nil, fmt.Errorf("pkcs12: unsupported AuthSafe content type: %v", pfx.AuthSafe.ContentType)
+	}
+
+	var authSafe []byte
+	if _, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, nil, fmt.Errorf("pkcs12: %v", err)
+	}
+
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err = verifyPkcs12Mac(&pfx.MacData, authSafe, passphrase); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var infos []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &infos); err != nil {
+		return nil, nil, fmt.Errorf("pkcs12: %v", err)
+	}
+
+	for _, info := range infos {
+		var safeContentsDER []byte
+
+		switch {
+		case info.ContentType.Equal(oidPkcs7Data):
+			if _, err = asn1.Unmarshal(info.Content.Bytes, &safeContentsDER); err != nil {
+				return nil, nil, fmt.Errorf("pkcs12: %v", err)
+			}
+
+		case info.ContentType.Equal(oidPkcs7EncryptedData):
+			var ed encryptedData
+			if _, err = asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+				return nil, nil, fmt.Errorf("pkcs12: %v", err)
+			}
+			safeContentsDER, err = decryptPbes1(&ed.EncryptedContentInfo.ContentEncryptionAlgorithm,
+				ed.EncryptedContentInfo.EncryptedContent, passphrase)
+			if err != nil {
+				return nil, nil, err
+			}
+
+		default:
+			continue
+		}
+
+		var bags []safeBag
+		if _, err = asn1.Unmarshal(safeContentsDER, &bags); err != nil {
+			return nil, nil, fmt.Errorf("pkcs12: %v", err)
+		}
+
+		for _, bag := range bags {
+			switch {
+			case key == nil && bag.Id.Equal(oidKeyBag):
+				key, err = x509.ParsePKCS8PrivateKey(bag.Value.Bytes)
+				if err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: keyBag: %v", err)
+				}
+
+			case key == nil && bag.Id.Equal(oidPkcs8ShroudedKeyBag):
+				var wrapper pkcs5
+				if _, err = asn1.Unmarshal(bag.Value.Bytes, &wrapper); err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: %v", err)
+				}
+				key, err = decryptShroudedKeyBag(&wrapper, passphrase)
+				if err != nil {
+					return nil, nil, err
+				}
+
+			case cert == nil && bag.Id.Equal(oidCertBag):
+				var cb certBag
+				if _, err = asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: %v", err)
+				}
+				if !cb.Id.Equal(oidCertTypeX509) {
+					continue
+				}
+				var certDER []byte
+				if _, err = asn1.Unmarshal(cb.Value.Bytes, &certDER); err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: %v", err)
+				}
+				cert, err = x509.ParseCertificate(certDER)
+				if err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: certBag: %v", err)
+				}
+			}
+		}
+	}
+
+	if key == nil {
+		return nil, nil, fmt.Errorf("pkcs12: no private key found in bundle")
+	}
+
+	return key, cert, nil
+}
+
+// decryptShroudedKeyBag unwraps a pkcs8ShroudedKeyBag, whose contents
+// are a PBES1 or PBES2 EncryptedPrivateKeyInfo identical in shape to
+// the top-level structure parseEncryptedPrivateKey decodes.
+func decryptShroudedKeyBag(wrapper *pkcs5, passphrase []byte) (interface{}, error) {
+	if wrapper.Algo.Algorithm.Equal(oidPbes2) {
+		return decryptPbes2(wrapper, passphrase)
+	}
+
+	plain, err := decryptPbes1(&wrapper.Algo, wrapper.Encrypted, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS8PrivateKey(plain)
+}
+
+// decryptPbes1 decrypts data encrypted with one of the PBES1 schemes
+// of PKCS#12 Appendix C.  Only 3-key triple-DES-CBC is supported here;
+// pbeWithSHAAnd40BitRC2-CBC is handled by pkcs12_rc2.go when built with
+// the `pkcs12rc2` tag.
+func decryptPbes1(algo *pkix.AlgorithmIdentifier, data, passphrase []byte) ([]byte, error) {
+	if !algo.Algorithm.Equal(oidPbeWithSHAAnd3KeyTripleDESCBC) {
+		if plain, ok, err := decryptPbes1RC2(algo, data, passphrase); ok {
+			return plain, err
+		}
+		return nil, fmt.Errorf("pkcs12: unsupported PBES1 scheme: %v", algo.Algorithm)
+	}
+
+	var params pbeParams
+	if _, err := asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("pkcs12: %v", err)
+	}
+
+	key := pkcs12KDF(1, passphrase, params.Salt, params.Iterations, 24, sha1.New)
+	iv := pkcs12KDF(2, passphrase, params.Salt, params.Iterations, 8, sha1.New)
+
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("pkcs12: encrypted content is not a multiple of the block size")
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	return checkPkcs7Padding(plain, block.BlockSize())
+}
+
+func verifyPkcs12Mac(m *macData, authSafe, passphrase []byte) error {
+	var hashNew hashFunc
+	switch {
+	case m.Mac.Algorithm.Algorithm.Equal(oidSHA1):
+		hashNew = sha1.New
+	default:
+		return fmt.Errorf("pkcs12: unsupported MAC digest algorithm: %v", m.Mac.Algorithm.Algorithm)
+	}
+
+	h := hashNew()
+	key := pkcs12KDF(3, passphrase, m.MacSalt, m.Iterations, h.Size(), hashNew)
+
+	mac := hmac.New(hashNew, key)
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), m.Mac.Digest) {
+		return fmt.Errorf("pkcs12: MAC mismatch: incorrect passphrase?")
+	}
+
+	return nil
+}
+
+// bmpString encodes s as UCS-2BE with a trailing NUL, the password
+// encoding PKCS#12 requires (RFC 7292 Appendix B.1).
+func bmpString(s []byte) []byte {
+	ret := make([]byte, 0, 2*len(s)+2)
+	for _, b := range s {
+		ret = append(ret, 0, b)
+	}
+	return append(ret, 0, 0)
+}
+
+// pkcs12KDF implements the PBE KDF of RFC 7292 Appendix B.  id selects
+// the purpose of the derived material (1 = key, 2 = IV, 3 = MAC key).
+func pkcs12KDF(id byte, passphrase, salt []byte, iterations, size int, hashNew hashFunc) []byte {
+	h := hashNew()
+	u := h.Size()
+	v := h.BlockSize()
+
+	password := bmpString(passphrase)
+
+	D := bytes.Repeat([]byte{id}, v)
+	S := fillWithRepeats(salt, v)
+	P := fillWithRepeats(password, v)
+	I := append(append([]byte{}, S...), P...)
+
+	count := (size + u - 1) / u
+	A := make([]byte, 0, count*u)
+
+	for i := 0; i < count; i++ {
+		h.Reset()
+		h.Write(D)
+		h.Write(I)
+		Ai := h.Sum(nil)
+		for round := 1; round < iterations; round++ {
+			h.Reset()
+			h.Write(Ai)
+			Ai = h.Sum(nil)
+		}
+		A = append(A, Ai...)
+
+		B := fillWithRepeats(Ai, v)
+		for j := 0; j < len(I); j += v {
+			addOneWithCarry(I[j:j+v], B)
+		}
+	}
+
+	return A[:size]
+}
+
+func fillWithRepeats(pattern []byte, v int) []byte {
+	if len(pattern) == 0 {
+		return make([]byte, v)
+	}
+	outLen := v * ((len(pattern) + v - 1) / v)
+	out := make([]byte, outLen)
+	for i := 0; i < outLen; i++ {
+		out[i] = pattern[i%len(pattern)]
+	}
+	return out
+}
+
+// addOneWithCarry computes a = (a + b + 1) mod 2^(8*len(a)), treating
+// a and b as big-endian integers, in place.
+func addOneWithCarry(a, b []byte) {
+	carry := 1
+	for i := len(a) - 1; i >= 0; i-- {
+		sum := int(a[i]) + int(b[i]) + carry
+		a[i] = byte(sum)
+		carry = sum >> 8
+	}
+}
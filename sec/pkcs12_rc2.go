@@ -0,0 +1,67 @@
+//go:build pkcs12rc2
+// +build pkcs12rc2
+
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"golang.org/x/crypto/rc2"
+)
+
+// decryptPbes1RC2 decrypts the pbeWithSHAAnd40BitRC2-CBC scheme some
+// tools (notably older OpenSSL and Windows) use for the certificate
+// SafeContents of a PKCS#12 bundle.  Only linked in when built with
+// -tags pkcs12rc2, since 40-bit RC2 is export-grade and not something
+// we want to pull in unconditionally.
+func decryptPbes1RC2(algo *pkix.AlgorithmIdentifier, data, passphrase []byte) (plain []byte, handled bool, err error) {
+	if !algo.Algorithm.Equal(oidPbeWithSHAAnd40BitRC2CBC) {
+		return nil, false, nil
+	}
+
+	var params pbeParams
+	if _, err = asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+		return nil, true, fmt.Errorf("pkcs12: %v", err)
+	}
+
+	key := pkcs12KDF(1, passphrase, params.Salt, params.Iterations, 5, sha1.New)
+	iv := pkcs12KDF(2, passphrase, params.Salt, params.Iterations, 8, sha1.New)
+
+	block, err := rc2.New(key, len(key)*8)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if len(data)%block.BlockSize() != 0 {
+		return nil, true, fmt.Errorf("pkcs12: encrypted content is not a multiple of the block size")
+	}
+
+	plain = make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	plain, err = checkPkcs7Padding(plain, block.BlockSize())
+	return plain, true, err
+}
@@ -0,0 +1,35 @@
+//go:build !pkcs12rc2
+// +build !pkcs12rc2
+
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import "crypto/x509/pkix"
+
+// decryptPbes1RC2 is stubbed out by default: pulling in RC2 for the
+// (legacy, export-grade) pbeWithSHAAnd40BitRC2-CBC scheme is opt-in.
+// Build with -tags pkcs12rc2 to link pkcs12_rc2.go instead.
+func decryptPbes1RC2(algo *pkix.AlgorithmIdentifier, data, passphrase []byte) (plain []byte, handled bool, err error) {
+	if !algo.Algorithm.Equal(oidPbeWithSHAAnd40BitRC2CBC) {
+		return nil, false, nil
+	}
+	return nil, true, errRC2Disabled
+}
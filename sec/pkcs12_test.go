@@ -0,0 +1,131 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+)
+
+// loadRsaModulus returns the modulus of the RSA key in testdata/rsa_key.pem,
+// so fixtures decoded by other tests can be checked against it.
+func loadRsaModulus(t *testing.T) []byte {
+	t.Helper()
+
+	pemBytes, err := os.ReadFile("testdata/rsa_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		t.Fatal("testdata/rsa_key.pem: no PEM block")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(*rsa.PrivateKey).N.Bytes()
+}
+
+// fixtures produced with the real PKCS#12 and PBES2 fixtures in this
+// package were all generated with OpenSSL 3.0.17, e.g.:
+//
+//	openssl pkcs12 -export -inkey rsa_key.pem -nocerts \
+//	    -out key_modern.p12 -passout pass:modernpass -macalg sha1
+//
+// both contain the same RSA key as testdata/rsa_key.pem.
+
+// TestParsePkcs12KeyModern decodes a PKCS#12 bundle whose shrouded key
+// bag is PBES2/PBKDF2/AES-256-CBC, as OpenSSL 3.x produces by default.
+func TestParsePkcs12KeyModern(t *testing.T) {
+	testParsePkcs12Fixture(t, "testdata/key_modern.p12", "modernpass")
+}
+
+// TestParsePkcs12KeyLegacy decodes a PKCS#12 bundle whose shrouded key
+// bag uses the legacy PBES1 pbeWithSHAAnd3-KeyTripleDES-CBC scheme, as
+// produced by `openssl pkcs12 -legacy`.
+func TestParsePkcs12KeyLegacy(t *testing.T) {
+	testParsePkcs12Fixture(t, "testdata/key_legacy.p12", "legacypass")
+}
+
+func testParsePkcs12Fixture(t *testing.T, path, pass string) {
+	t.Helper()
+
+	der, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, cert, err := parsePkcs12(der, []byte(pass))
+	if err != nil {
+		t.Fatalf("parsePkcs12: %v", err)
+	}
+	if cert != nil {
+		t.Fatalf("expected no certificate, got %v", cert)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", key)
+	}
+	if want := loadRsaModulus(t); rsaKey.N.Bytes() == nil || string(rsaKey.N.Bytes()) != string(want) {
+		t.Fatalf("decoded key doesn't match testdata/rsa_key.pem")
+	}
+}
+
+// TestParsePkcs12WrongPassword confirms the MAC check rejects an
+// incorrect passphrase rather than silently returning garbage.
+func TestParsePkcs12WrongPassword(t *testing.T) {
+	der, err := os.ReadFile("testdata/key_modern.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := parsePkcs12(der, []byte("not the password")); err == nil {
+		t.Fatal("expected an error for an incorrect passphrase")
+	}
+}
+
+// TestParseEncryptedPrivateKeyPkcs12 exercises the PKCS#12 fallback in
+// parseEncryptedPrivateKey itself: a PFX's top-level structure (an
+// INTEGER Version, not an AlgorithmIdentifier) fails the initial
+// pkcs5-wrapper unmarshal, so the function must fall through to
+// parsePkcs12 rather than returning that error.
+func TestParseEncryptedPrivateKeyPkcs12(t *testing.T) {
+	der, err := os.ReadFile("testdata/key_modern.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	KeyPassword = []byte("modernpass")
+	defer func() { KeyPassword = nil }()
+
+	key, err := parseEncryptedPrivateKey(der, "test")
+	if err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", key)
+	}
+}
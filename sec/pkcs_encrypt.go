@@ -0,0 +1,275 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Encoder for PBES2/PBKDF2/AES-CBC encrypted PKCS#8 private keys.
+// This is the write-side counterpart of parseEncryptedPrivateKey: it
+// produces the same DER structure emitted by MCUboot's `imgtool.py` or
+// `openssl genpkey ... -aes-256-cbc`, so the output can be read back by
+// this package (or those tools) without any further conversion.
+package sec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Cipher selects the symmetric cipher used to protect the PKCS#8
+// payload.
+type Cipher int
+
+const (
+	AES128CBC Cipher = iota
+	AES256CBC
+)
+
+// PRF selects the pseudorandom function PBKDF2 uses to derive the
+// encryption key from the passphrase.
+type PRF int
+
+const (
+	SHA1PRF PRF = iota
+	SHA224PRF
+	SHA256PRF
+)
+
+// KDF selects the PBES2 key derivation function EncryptPrivateKey
+// uses to turn the passphrase into the cipher key.
+type KDF int
+
+const (
+	KDFPBKDF2 KDF = iota
+	KDFScrypt
+)
+
+// EncryptOptions controls how EncryptPrivateKey derives its key and
+// encrypts the PKCS#8 payload.  A nil *EncryptOptions is equivalent to
+// passing DefaultEncryptOptions.
+type EncryptOptions struct {
+	Cipher Cipher
+	KDF    KDF
+
+	// PRF and IterCount are only used when KDF is KDFPBKDF2.
+	PRF       PRF
+	IterCount int
+
+	// ScryptN, ScryptR, and ScryptP are only used when KDF is
+	// KDFScrypt; see golang.org/x/crypto/scrypt.Key.  Zero values
+	// fall back to N=32768, r=8, p=1.
+	ScryptN int
+	ScryptR int
+	ScryptP int
+
+	SaltLen int
+}
+
+// DefaultEncryptOptions matches what `imgtool.py` and
+// `openssl genpkey ... -aes-256-cbc` produce: AES-256-CBC,
+// PBKDF2/HMAC-SHA256, 100k iterations, and a 16-byte salt.
+var DefaultEncryptOptions = EncryptOptions{
+	Cipher:    AES256CBC,
+	KDF:       KDFPBKDF2,
+	PRF:       SHA256PRF,
+	IterCount: 100000,
+	SaltLen:   16,
+}
+
+const (
+	defaultScryptN = 32768
+	defaultScryptR = 8
+	defaultScryptP = 1
+)
+
+// EncryptPrivateKey marshals key (an RSA or ECDSA private key, as
+// accepted by x509.MarshalPKCS8PrivateKey) to PKCS#8, then encrypts it
+// with PBES2/PBKDF2 using opts, producing the same ASN.1 layout that
+// parseEncryptedPrivateKey expects.
+func EncryptPrivateKey(key interface{}, passphrase []byte, opts *EncryptOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &DefaultEncryptOptions
+	}
+
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	keySize, oidCipher, err := cipherParams(opts.Cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	saltLen := opts.SaltLen
+	if saltLen == 0 {
+		saltLen = DefaultEncryptOptions.SaltLen
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	var cryptoKey []byte
+	var kdfAlgo pkix.AlgorithmIdentifier
+
+	switch opts.KDF {
+	case KDFScrypt:
+		n, r, p := opts.ScryptN, opts.ScryptR, opts.ScryptP
+		if n == 0 {
+			n = defaultScryptN
+		}
+		if r == 0 {
+			r = defaultScryptR
+		}
+		if p == 0 {
+			p = defaultScryptP
+		}
+
+		cryptoKey, err = scrypt.Key(passphrase, salt, n, r, p, keySize)
+		if err != nil {
+			return nil, fmt.Errorf("sec: scrypt: %v", err)
+		}
+
+		kdfParamDER, err := asn1.Marshal(scryptParams{
+			Salt:                     salt,
+			CostParameter:            n,
+			BlockSize:                r,
+			ParallelizationParameter: p,
+			KeyLength:                keySize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		kdfAlgo = pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfParamDER}}
+
+	default:
+		oidPRF, hashNew, err := prfParams(opts.PRF)
+		if err != nil {
+			return nil, err
+		}
+
+		iterCount := opts.IterCount
+		if iterCount == 0 {
+			iterCount = DefaultEncryptOptions.IterCount
+		}
+		cryptoKey = pbkdf2.Key(passphrase, salt, iterCount, keySize, hashNew)
+
+		kdfParamDER, err := asn1.Marshal(pbkdf2Param{
+			Salt:      salt,
+			IterCount: iterCount,
+			HashFunc:  pkix.AlgorithmIdentifier{Algorithm: oidPRF, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return nil, err
+		}
+		kdfAlgo = pkix.AlgorithmIdentifier{Algorithm: oidPbkdf2, Parameters: asn1.RawValue{FullBytes: kdfParamDER}}
+	}
+
+	block, err := aes.NewCipher(cryptoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	padded := addPkcs7Padding(plain, block.BlockSize())
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	pbparmDER, err := asn1.Marshal(pbes2{
+		KeyDerivationFunc: kdfAlgo,
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidCipher, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs5{
+		Algo:      pkix.AlgorithmIdentifier{Algorithm: oidPbes2, Parameters: asn1.RawValue{FullBytes: pbparmDER}},
+		Encrypted: encrypted,
+	})
+}
+
+// EncryptPrivateKeyPEM is EncryptPrivateKey, but returns a PEM-encoded
+// "ENCRYPTED PRIVATE KEY" block, matching what `openssl genpkey`
+// writes to disk.
+func EncryptPrivateKeyPEM(key interface{}, passphrase []byte, opts *EncryptOptions) ([]byte, error) {
+	der, err := EncryptPrivateKey(key, passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "ENCRYPTED PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
+
+func cipherParams(c Cipher) (keySize int, oid asn1.ObjectIdentifier, err error) {
+	switch c {
+	case AES128CBC:
+		return 16, oidAes128CBC, nil
+	case AES256CBC:
+		return 32, oidAes256CBC, nil
+	default:
+		return 0, nil, fmt.Errorf("sec: unknown Cipher %d", c)
+	}
+}
+
+func prfParams(p PRF) (oid asn1.ObjectIdentifier, h hashFunc, err error) {
+	switch p {
+	case SHA1PRF:
+		return oidHmacWithSha1, sha1.New, nil
+	case SHA224PRF:
+		return oidHmacWithSha224, sha256.New224, nil
+	case SHA256PRF:
+		return oidHmacWithSha256, sha256.New, nil
+	default:
+		return nil, nil, fmt.Errorf("sec: unknown PRF %d", p)
+	}
+}
+
+// addPkcs7Padding pads buf out to a multiple of blockSize, the
+// counterpart of checkPkcs7Padding.
+func addPkcs7Padding(buf []byte, blockSize int) []byte {
+	padLen := blockSize - len(buf)%blockSize
+	padded := make([]byte, len(buf)+padLen)
+	copy(padded, buf)
+	for i := len(buf); i < len(padded); i++ {
+		padded[i] = byte(padLen)
+	}
+	return padded
+}
@@ -0,0 +1,203 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+)
+
+// TestEncryptPrivateKeyRoundTripDefault encrypts an RSA key with
+// DefaultEncryptOptions and confirms parseEncryptedPrivateKey decodes
+// it back to the same key.
+func TestEncryptPrivateKeyRoundTripDefault(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := EncryptPrivateKey(key, []byte("hunter2"), nil)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	KeyPassword = []byte("hunter2")
+	defer func() { KeyPassword = nil }()
+
+	got, err := parseEncryptedPrivateKey(der, "test")
+	if err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+
+	gotRsa, ok := got.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", got)
+	}
+	if gotRsa.N.Cmp(key.N) != 0 {
+		t.Fatal("decoded key doesn't match the original")
+	}
+}
+
+// TestEncryptPrivateKeyRoundTripOptions exercises a non-default
+// Cipher/PRF/IterCount combination, and an ECDSA key.
+func TestEncryptPrivateKeyRoundTripOptions(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &EncryptOptions{
+		Cipher:    AES128CBC,
+		KDF:       KDFPBKDF2,
+		PRF:       SHA1PRF,
+		IterCount: 1000,
+		SaltLen:   8,
+	}
+
+	der, err := EncryptPrivateKey(key, []byte("passphrase"), opts)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	KeyPassword = []byte("passphrase")
+	defer func() { KeyPassword = nil }()
+
+	got, err := parseEncryptedPrivateKey(der, "test")
+	if err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+
+	gotEcdsa, ok := got.(*ecdsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected *ecdsa.PrivateKey, got %T", got)
+	}
+	if gotEcdsa.D.Cmp(key.D) != 0 {
+		t.Fatal("decoded key doesn't match the original")
+	}
+}
+
+// TestEncryptPrivateKeyPEM confirms the PEM helper round-trips through
+// the same decoder, matching what imgtool.py and openssl genpkey write
+// to disk.
+func TestEncryptPrivateKeyPEM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemBytes, err := EncryptPrivateKeyPEM(key, []byte("hunter2"), nil)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKeyPEM: %v", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("unexpected PEM block: %v", block)
+	}
+
+	KeyPassword = []byte("hunter2")
+	defer func() { KeyPassword = nil }()
+
+	if _, err := parseEncryptedPrivateKey(block.Bytes, "test"); err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+}
+
+// TestEncryptPrivateKeyDefaultsIterCount confirms a partial
+// *EncryptOptions that sets KDF/PRF/Cipher but leaves IterCount zero
+// doesn't silently write IterCount: 0 into the marshaled pbkdf2Param
+// (which would derive the key with a single PBKDF2 iteration); it
+// should fall back to DefaultEncryptOptions.IterCount the same way a
+// zero SaltLen falls back to DefaultEncryptOptions.SaltLen.
+func TestEncryptPrivateKeyDefaultsIterCount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &EncryptOptions{
+		Cipher: AES256CBC,
+		KDF:    KDFPBKDF2,
+		PRF:    SHA256PRF,
+	}
+
+	der, err := EncryptPrivateKey(key, []byte("hunter2"), opts)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	var wrapper pkcs5
+	if _, err := asn1.Unmarshal(der, &wrapper); err != nil {
+		t.Fatalf("asn1.Unmarshal: %v", err)
+	}
+	var params pbes2
+	if _, err := asn1.Unmarshal(wrapper.Algo.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("asn1.Unmarshal pbes2: %v", err)
+	}
+	var kdfParam pbkdf2Param
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParam); err != nil {
+		t.Fatalf("asn1.Unmarshal pbkdf2Param: %v", err)
+	}
+	if kdfParam.IterCount != DefaultEncryptOptions.IterCount {
+		t.Fatalf("IterCount = %d, want the default %d", kdfParam.IterCount, DefaultEncryptOptions.IterCount)
+	}
+}
+
+// TestEncryptPrivateKeyInvalidCipherPRF confirms an out-of-range
+// Cipher or PRF returns an error instead of panicking.
+func TestEncryptPrivateKeyInvalidCipherPRF(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := EncryptPrivateKey(key, []byte("hunter2"), &EncryptOptions{Cipher: Cipher(99), KDF: KDFPBKDF2, PRF: SHA256PRF}); err == nil {
+		t.Fatal("expected an error for an invalid Cipher")
+	}
+	if _, err := EncryptPrivateKey(key, []byte("hunter2"), &EncryptOptions{Cipher: AES256CBC, KDF: KDFPBKDF2, PRF: PRF(99)}); err == nil {
+		t.Fatal("expected an error for an invalid PRF")
+	}
+}
+
+// TestEncryptPrivateKeyWrongPassphrase confirms decoding with the
+// wrong passphrase doesn't produce a valid key.
+func TestEncryptPrivateKeyWrongPassphrase(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := EncryptPrivateKey(key, []byte("hunter2"), nil)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	KeyPassword = []byte("wrong passphrase")
+	defer func() { KeyPassword = nil }()
+
+	if _, err := parseEncryptedPrivateKey(der, "test"); err == nil {
+		t.Fatal("expected an error decoding with the wrong passphrase")
+	}
+}
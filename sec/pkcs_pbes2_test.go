@@ -0,0 +1,225 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// testdata/rsa_3des.pem, rsa_sha384.pem, and rsa_sha512.pem were
+// produced with OpenSSL 3.0.17, e.g.:
+//
+//	openssl pkcs8 -topk8 -v2 des-ede3-cbc -in rsa_key.pem -out rsa_3des.pem
+//	openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA384 -in rsa_key.pem -out rsa_sha384.pem
+
+func TestDecryptFixture3DES(t *testing.T) {
+	testDecryptPbes2Fixture(t, "testdata/rsa_3des.pem", "despass")
+}
+
+func TestDecryptFixtureHmacSha384(t *testing.T) {
+	testDecryptPbes2Fixture(t, "testdata/rsa_sha384.pem", "sha384pass")
+}
+
+func TestDecryptFixtureHmacSha512(t *testing.T) {
+	testDecryptPbes2Fixture(t, "testdata/rsa_sha512.pem", "sha512pass")
+}
+
+func testDecryptPbes2Fixture(t *testing.T, path, pass string) {
+	t.Helper()
+
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		t.Fatalf("%s: no PEM block", path)
+	}
+
+	KeyPassword = []byte(pass)
+	defer func() { KeyPassword = nil }()
+
+	key, err := parseEncryptedPrivateKey(block.Bytes, "test")
+	if err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", key)
+	}
+}
+
+// TestDecryptGcm builds a PBES2/PBKDF2/AES-GCM EncryptedPrivateKeyInfo
+// by hand (OpenSSL's `pkcs8` command has no support for AEAD ciphers)
+// and confirms decryptPbes2 unwraps it via the cipherSpecs AEAD path.
+func TestDecryptGcm(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cipher  cipherSpec
+		keySize int
+	}{
+		{"AES-128-GCM", *lookupCipherSpec(oidAes128GCM), 16},
+		{"AES-192-GCM", *lookupCipherSpec(oidAes192GCM), 24},
+		{"AES-256-GCM", *lookupCipherSpec(oidAes256GCM), 32},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pass := []byte("hunter2")
+			salt := make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				t.Fatal(err)
+			}
+			const iterCount = 1000
+			cryptoKey := pbkdf2.Key(pass, salt, iterCount, tc.keySize, lookupPRF(oidHmacWithSha256))
+
+			block, err := aes.NewCipher(cryptoKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			aead, err := cipher.NewGCM(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := make([]byte, aead.NonceSize())
+			if _, err := rand.Read(nonce); err != nil {
+				t.Fatal(err)
+			}
+			encrypted := aead.Seal(nil, nonce, plain, nil)
+
+			gcmParamDER, err := asn1.Marshal(gcmParameters{Nonce: nonce, ICVLen: aead.Overhead()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			kdfParamDER, err := asn1.Marshal(pbkdf2Param{
+				Salt:      salt,
+				IterCount: iterCount,
+				HashFunc:  pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSha256, Parameters: asn1.NullRawValue},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pbparmDER, err := asn1.Marshal(pbes2{
+				KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPbkdf2, Parameters: asn1.RawValue{FullBytes: kdfParamDER}},
+				EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: tc.cipher.oid, Parameters: asn1.RawValue{FullBytes: gcmParamDER}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, err := asn1.Marshal(pkcs5{
+				Algo:      pkix.AlgorithmIdentifier{Algorithm: oidPbes2, Parameters: asn1.RawValue{FullBytes: pbparmDER}},
+				Encrypted: encrypted,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			KeyPassword = pass
+			defer func() { KeyPassword = nil }()
+
+			got, err := parseEncryptedPrivateKey(der, "test")
+			if err != nil {
+				t.Fatalf("parseEncryptedPrivateKey: %v", err)
+			}
+			gotRsa, ok := got.(*rsa.PrivateKey)
+			if !ok {
+				t.Fatalf("expected *rsa.PrivateKey, got %T", got)
+			}
+			if gotRsa.N.Cmp(key.N) != 0 {
+				t.Fatal("decoded key doesn't match the original")
+			}
+		})
+	}
+}
+
+// TestDecryptGcmBadNonceLength confirms a malformed GCM nonce length
+// (file-controlled DER, not necessarily the standard 12 bytes)
+// produces an error instead of the panic aead.Open raises on a
+// mismatched nonce size.
+func TestDecryptGcmBadNonceLength(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pass := []byte("hunter2")
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+	const iterCount = 1000
+
+	// A 3-byte nonce: too short for the GCM this cipherSpec always
+	// constructs via cipher.NewGCMWithTagSize (standard 12-byte nonce).
+	gcmParamDER, err := asn1.Marshal(gcmParameters{Nonce: []byte{1, 2, 3}, ICVLen: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdfParamDER, err := asn1.Marshal(pbkdf2Param{
+		Salt:      salt,
+		IterCount: iterCount,
+		HashFunc:  pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSha256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbparmDER, err := asn1.Marshal(pbes2{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPbkdf2, Parameters: asn1.RawValue{FullBytes: kdfParamDER}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAes256GCM, Parameters: asn1.RawValue{FullBytes: gcmParamDER}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(pkcs5{
+		Algo:      pkix.AlgorithmIdentifier{Algorithm: oidPbes2, Parameters: asn1.RawValue{FullBytes: pbparmDER}},
+		Encrypted: plain, // contents don't matter; Open must fail before using them
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	KeyPassword = pass
+	defer func() { KeyPassword = nil }()
+
+	if _, err := parseEncryptedPrivateKey(der, "test"); err == nil {
+		t.Fatal("expected an error for a malformed GCM nonce, not success")
+	}
+}
@@ -0,0 +1,93 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sec
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"os"
+	"testing"
+)
+
+// TestDecryptScryptFixture decodes testdata/rsa_scrypt.pem, an RSA key
+// encrypted with `openssl pkcs8 -topk8 -scrypt` (OpenSSL 3.0.17),
+// locking down the scryptParams ASN.1 layout against a real encoder.
+func TestDecryptScryptFixture(t *testing.T) {
+	pemBytes, err := os.ReadFile("testdata/rsa_scrypt.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		t.Fatal("testdata/rsa_scrypt.pem: no PEM block")
+	}
+
+	KeyPassword = []byte("scryptpass")
+	defer func() { KeyPassword = nil }()
+
+	key, err := parseEncryptedPrivateKey(block.Bytes, "test")
+	if err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", key)
+	}
+}
+
+// TestEncryptPrivateKeyScryptRoundTrip encrypts with KDF: KDFScrypt and
+// confirms parseEncryptedPrivateKey (the same decoder exercised above
+// against a real OpenSSL fixture) decodes it back to the same key.
+func TestEncryptPrivateKeyScryptRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &EncryptOptions{
+		Cipher:  AES256CBC,
+		KDF:     KDFScrypt,
+		ScryptN: 1024, // small, just for test speed
+		ScryptR: 8,
+		ScryptP: 1,
+		SaltLen: 16,
+	}
+
+	der, err := EncryptPrivateKey(key, []byte("hunter2"), opts)
+	if err != nil {
+		t.Fatalf("EncryptPrivateKey: %v", err)
+	}
+
+	KeyPassword = []byte("hunter2")
+	defer func() { KeyPassword = nil }()
+
+	got, err := parseEncryptedPrivateKey(der, "test")
+	if err != nil {
+		t.Fatalf("parseEncryptedPrivateKey: %v", err)
+	}
+
+	gotRsa, ok := got.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected *rsa.PrivateKey, got %T", got)
+	}
+	if gotRsa.N.Cmp(key.N) != 0 {
+		t.Fatal("decoded key doesn't match the original")
+	}
+}